@@ -0,0 +1,143 @@
+// yggcrawl
+// Copyright (C) 2020 Neil Alexander
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// 	the Free Software Foundation, either version 3 of the License, or
+// 	(at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/admin"
+)
+
+// registerAdminHandlers wires up the crawler-specific endpoints on the admin
+// socket, alongside whatever yggdrasil core endpoints (getSelf, getPeers,
+// ...) the admin package registers on its own.
+func (n *node) registerAdminHandlers() {
+	_ = n.admin.AddHandler("getCrawlStatus", []string{}, n.admingetCrawlStatus)
+	_ = n.admin.AddHandler("getCrawlTopology", []string{}, n.admingetCrawlTopology)
+	_ = n.admin.AddHandler("getCrawlNodeInfo", []string{"[key]", "[ipv6prefix]"}, n.admingetCrawlNodeInfo)
+	_ = n.admin.AddHandler("restartCrawl", []string{}, n.adminrestartCrawl)
+	_ = n.admin.AddHandler("stopCrawl", []string{}, n.adminstopCrawl)
+}
+
+// admingetCrawlStatus reports how far the current (or most recent) crawl has
+// got - elapsed time and the size of the DHT/nodeinfo visited sets.
+func (n *node) admingetCrawlStatus(in admin.Info) (admin.Info, error) {
+	n.crawlMutex.Lock()
+	crawling := n.crawling
+	start := n.crawlStart
+	n.crawlMutex.Unlock()
+
+	n.dhtMutex.RLock()
+	dhtVisited := len(n.dhtVisited)
+	n.dhtMutex.RUnlock()
+
+	n.nodeInfoMutex.RLock()
+	nodeInfoVisited := len(n.nodeInfoVisited)
+	n.nodeInfoMutex.RUnlock()
+
+	return admin.Info{
+		"crawling":         crawling,
+		"elapsed_seconds":  time.Since(start).Seconds(),
+		"dht_visited":      dhtVisited,
+		"nodeinfo_visited": nodeInfoVisited,
+	}, nil
+}
+
+// admingetCrawlTopology returns a snapshot of the DHT nodes visited so far,
+// using the same schema as the "topology" key in the final results.json.
+func (n *node) admingetCrawlTopology(in admin.Info) (admin.Info, error) {
+	n.dhtMutex.RLock()
+	defer n.dhtMutex.RUnlock()
+
+	topology := make(admin.Info, len(n.dhtVisited))
+	for key, a := range n.dhtVisited {
+		entry := admin.Info{
+			"node_id":     a.NodeID,
+			"ipv6_addr":   a.IPv6Addr,
+			"ipv6_subnet": a.IPv6Subnet,
+			"coords":      a.Coords,
+			"found":       a.Found,
+		}
+		if a.Reachable {
+			entry["reachable"] = a.Reachable
+		}
+		if a.SessionRTT != 0 {
+			entry["session_rtt"] = a.SessionRTT
+		}
+		topology[key] = entry
+	}
+	return admin.Info{"topology": topology}, nil
+}
+
+// admingetCrawlNodeInfo returns a snapshot of the nodeinfo visited so far,
+// optionally filtered down to a single public key or an IPv6 prefix.
+func (n *node) admingetCrawlNodeInfo(in admin.Info) (admin.Info, error) {
+	key, _ := in["key"].(string)
+	ipv6prefix, _ := in["ipv6prefix"].(string)
+
+	n.dhtMutex.RLock()
+	n.nodeInfoMutex.RLock()
+	defer n.dhtMutex.RUnlock()
+	defer n.nodeInfoMutex.RUnlock()
+
+	nodeinfo := make(admin.Info, len(n.nodeInfoVisited))
+	for k, v := range n.nodeInfoVisited {
+		if key != "" && k != key {
+			continue
+		}
+		if ipv6prefix != "" {
+			if a, ok := n.dhtVisited[k]; !ok || !strings.HasPrefix(a.IPv6Addr, ipv6prefix) {
+				continue
+			}
+		}
+		nodeinfo[k] = v
+	}
+	return admin.Info{"nodeinfo": nodeinfo}, nil
+}
+
+// adminrestartCrawl kicks off a fresh crawl in the background. It is a no-op
+// (reported back to the caller) if one is already in progress.
+func (n *node) adminrestartCrawl(in admin.Info) (admin.Info, error) {
+	n.crawlMutex.Lock()
+	alreadyCrawling := n.crawling
+	n.crawlMutex.Unlock()
+
+	if alreadyCrawling {
+		return admin.Info{"success": false, "error": "a crawl is already in progress"}, nil
+	}
+	go n.runCrawl()
+	return admin.Info{"success": true}, nil
+}
+
+// adminstopCrawl gracefully stops the in-progress crawl, flushing whatever
+// partial results have been gathered so far.
+func (n *node) adminstopCrawl(in admin.Info) (admin.Info, error) {
+	n.crawlMutex.Lock()
+	crawling := n.crawling
+	n.crawlMutex.Unlock()
+
+	if !crawling {
+		return admin.Info{"success": false, "error": "no crawl is in progress"}, nil
+	}
+	// Stop admitting new rumours, but let in-flight DHT pings and nodeinfo
+	// lookups finish up in the background before their next restartCrawl.
+	atomic.StoreInt32(&n.stopping, 1)
+	n.writeResults()
+	return admin.Info{"success": true}, nil
+}
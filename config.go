@@ -0,0 +1,134 @@
+// yggcrawl
+// Copyright (C) 2020 Neil Alexander
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// 	the Free Software Foundation, either version 3 of the License, or
+// 	(at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+
+	hjson "github.com/hjson/hjson-go"
+	"github.com/mitchellh/mapstructure"
+	"github.com/yggdrasil-network/yggdrasil-go/src/config"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// crawlerConfig extends the regular yggdrasil NodeConfig with the handful of
+// extra options that are specific to the crawler itself, such as where to
+// write the results and how hard to retry a node before giving up on it.
+type crawlerConfig struct {
+	config.NodeConfig     `hjson:",inline" mapstructure:",squash"`
+	OutputFile            string `hjson:"OutputFile" mapstructure:"OutputFile"`
+	DHTBootstrapThreshold int    `hjson:"DHTBootstrapThreshold" mapstructure:"DHTBootstrapThreshold"`
+	RetryCount            int    `hjson:"RetryCount" mapstructure:"RetryCount"`
+	SessionProbeTimeout   int    `hjson:"SessionProbeTimeout" mapstructure:"SessionProbeTimeout"`
+	SessionProbeEnabled   bool   `hjson:"SessionProbeEnabled" mapstructure:"SessionProbeEnabled"`
+	SessionProbeWorkers   int    `hjson:"SessionProbeWorkers" mapstructure:"SessionProbeWorkers"`
+	DHTWorkers            int    `hjson:"DHTWorkers" mapstructure:"DHTWorkers"`
+	NodeInfoWorkers       int    `hjson:"NodeInfoWorkers" mapstructure:"NodeInfoWorkers"`
+	RetryBase             int    `hjson:"RetryBase" mapstructure:"RetryBase"`
+	RetryCap              int    `hjson:"RetryCap" mapstructure:"RetryCap"`
+}
+
+// generateCrawlerConfig produces a crawlerConfig with sane defaults, layering
+// the crawler-specific options on top of the values that config.GenerateConfig
+// already fills in for the embedded NodeConfig.
+func generateCrawlerConfig() *crawlerConfig {
+	cfg := &crawlerConfig{
+		NodeConfig:            *config.GenerateConfig(),
+		OutputFile:            "results.json",
+		DHTBootstrapThreshold: 3,
+		RetryCount:            5,
+		SessionProbeTimeout:   5000,
+		SessionProbeEnabled:   false,
+		SessionProbeWorkers:   16,
+		DHTWorkers:            32,
+		NodeInfoWorkers:       16,
+		RetryBase:             1000,
+		RetryCap:              60000,
+	}
+	cfg.NodeInfo = map[string]interface{}{
+		"name": "Yggdrasil Crawler",
+	}
+	cfg.AdminListen = "none"
+	cfg.SessionFirewall.Enable = true
+	cfg.SessionFirewall.AllowFromDirect = false
+	cfg.SessionFirewall.AllowFromRemote = false
+	cfg.SessionFirewall.AlwaysAllowOutbound = false
+	return cfg
+}
+
+// readConfig reads HJSON (or plain JSON, which is a subset of HJSON) either
+// from the given file or, if useconffile is empty, from stdin. It is layered
+// on top of a freshly generated config so that any field the caller omits
+// falls back to the same defaults that -genconf would produce.
+func readConfig(useconffile string) *crawlerConfig {
+	var conf []byte
+	var err error
+	if useconffile != "" {
+		conf, err = ioutil.ReadFile(useconffile)
+	} else {
+		conf, err = ioutil.ReadAll(os.Stdin)
+	}
+	if err != nil {
+		panic(err)
+	}
+	// Windows editors are still fond of emitting a UTF-16 BOM even when asked
+	// for UTF-8, so detect and transcode it back to UTF-8 before we try to
+	// parse the file as HJSON - a plain TrimPrefix wouldn't help here, since
+	// the body itself is still UTF-16.
+	if len(conf) >= 2 && (bytes.Equal(conf[0:2], []byte{0xFE, 0xFF}) || bytes.Equal(conf[0:2], []byte{0xFF, 0xFE})) {
+		utf := unicode.UTF16(unicode.BigEndian, unicode.UseBOM)
+		if bytes.Equal(conf[0:2], []byte{0xFF, 0xFE}) {
+			utf = unicode.UTF16(unicode.LittleEndian, unicode.UseBOM)
+		}
+		if conf, err = utf.NewDecoder().Bytes(conf); err != nil {
+			panic(err)
+		}
+	}
+	conf = bytes.TrimPrefix(conf, []byte{0xEF, 0xBB, 0xBF})
+
+	var dat map[string]interface{}
+	if err := hjson.Unmarshal(conf, &dat); err != nil {
+		panic(err)
+	}
+
+	cfg := generateCrawlerConfig()
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		TagName:          "mapstructure",
+		WeaklyTypedInput: true,
+		Result:           cfg,
+	})
+	if err != nil {
+		panic(err)
+	}
+	if err := decoder.Decode(dat); err != nil {
+		panic(err)
+	}
+	return cfg
+}
+
+// doGenconf marshals the given config back out as HJSON, in the same way
+// that upstream yggdrasil's -genconf/-normaliseconf flags do, so that the
+// output can be edited by hand and fed back in via -useconffile.
+func doGenconf(cfg *crawlerConfig) string {
+	bs, err := hjson.Marshal(cfg)
+	if err != nil {
+		panic(err)
+	}
+	return string(bs)
+}
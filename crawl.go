@@ -0,0 +1,141 @@
+// yggcrawl
+// Copyright (C) 2020 Neil Alexander
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// 	the Free Software Foundation, either version 3 of the License, or
+// 	(at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"sync/atomic"
+	"time"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/crypto"
+)
+
+// runCrawl performs a single sweep of the DHT starting from our own node,
+// waits for it (and the nodeinfo lookups it triggers) to finish, and then
+// writes the results out to disk. It is called once at startup, and can be
+// called again later - restartCrawl on the admin socket does exactly that.
+func (n *node) runCrawl() {
+	n.crawlMutex.Lock()
+	if n.crawling {
+		n.crawlMutex.Unlock()
+		n.log.Warnln("A crawl is already in progress, ignoring request to start another")
+		return
+	}
+	n.crawling = true
+	n.crawlStart = time.Now()
+	n.crawlMutex.Unlock()
+
+	// dhtVisited/nodeInfoVisited are guarded by dhtMutex/nodeInfoMutex
+	// everywhere else (dhtPing/nodeInfo, enqueueDHTPing/enqueueNodeInfo, and
+	// the admin socket handlers), so reassigning them here has to take those
+	// same locks rather than crawlMutex.
+	n.dhtMutex.Lock()
+	n.dhtVisited = make(map[string]attempt)
+	n.dhtMutex.Unlock()
+
+	n.nodeInfoMutex.Lock()
+	n.nodeInfoVisited = make(map[string]interface{})
+	n.nodeInfoMutex.Unlock()
+
+	// Nodes found by a previous crawl no longer apply, so drop their labels
+	// rather than leaving stale entries exposed on the metrics endpoint.
+	metricNodeInfo.Reset()
+
+	atomic.StoreInt32(&n.stopping, 0)
+
+	defer func() {
+		n.crawlMutex.Lock()
+		n.crawling = false
+		n.crawlMutex.Unlock()
+	}()
+
+	n.log.Infoln("Our network coords are", n.core.Coords())
+	n.log.Infoln("Starting crawl")
+
+	if key, err := hex.DecodeString(n.core.EncryptionPublicKey()); err == nil {
+		var pubkey crypto.BoxPubKey
+		copy(pubkey[:], key)
+		n.enqueueDHTPing(pubkey, n.core.Coords())
+	} else {
+		panic("failed to decode pub key")
+	}
+
+	n.dhtWaitGroup.Wait()
+	n.nodeInfoWaitGroup.Wait()
+	n.sessionWaitGroup.Wait()
+
+	n.writeResults()
+}
+
+// writeResults snapshots the current topology and nodeinfo maps and writes
+// them out to n.config.OutputFile. It is safe to call both at the end of a
+// full crawl and from the admin socket's stopCrawl handler, which needs to
+// flush whatever has been gathered so far.
+func (n *node) writeResults() {
+	n.dhtMutex.Lock()
+	n.nodeInfoMutex.Lock()
+	defer n.dhtMutex.Unlock()
+	defer n.nodeInfoMutex.Unlock()
+
+	n.log.Infoln("The crawl took", time.Since(n.crawlStart))
+
+	attempted := len(n.dhtVisited)
+	found := 0
+	reachable := 0
+	for _, a := range n.dhtVisited {
+		if a.Found {
+			found++
+		}
+		if a.Reachable {
+			reachable++
+		}
+	}
+
+	res := results{
+		Topology: &n.dhtVisited,
+		NodeInfo: &n.nodeInfoVisited,
+	}
+	res.Meta.GeneratedAtUTC = time.Now().UTC().Unix()
+	res.Meta.TimeTaken = time.Since(n.crawlStart).Seconds()
+	res.Meta.NodeInfoSuccessful = len(n.nodeInfoVisited)
+	res.Meta.NodeInfoFailed = found - len(n.nodeInfoVisited)
+	res.Meta.NodesAttempted = attempted
+	res.Meta.NodesSuccessful = found
+	res.Meta.NodesFailed = attempted - found
+	res.Meta.DHTVisible = found
+	res.Meta.SessionReachable = reachable
+
+	metricLastCrawlFinish.Set(float64(time.Now().Unix()))
+
+	if j, err := json.MarshalIndent(res, "", "\t"); err == nil {
+		if err := ioutil.WriteFile(n.config.OutputFile, j, 0644); err != nil {
+			n.log.Errorln("Failed to write", n.config.OutputFile, ":", err)
+		} else {
+			n.log.Infoln("Results written to", n.config.OutputFile)
+		}
+	} else {
+		n.log.Errorln("Failed to marshal results:", err)
+	}
+
+	n.log.Infoln(res.Meta.NodesAttempted, "nodes were processed")
+	n.log.Infoln(res.Meta.NodesSuccessful, "nodes were found")
+	n.log.Infoln(res.Meta.NodesFailed, "nodes were not found")
+	n.log.Infoln(res.Meta.NodeInfoSuccessful, "nodes responded with nodeinfo")
+	n.log.Infoln(res.Meta.NodeInfoFailed, "nodes did not respond with nodeinfo")
+}
@@ -0,0 +1,78 @@
+// yggcrawl
+// Copyright (C) 2020 Neil Alexander
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// 	the Free Software Foundation, either version 3 of the License, or
+// 	(at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/gologme/log"
+	gsyslog "github.com/hashicorp/go-syslog"
+)
+
+// logLevels lists the levels gologme understands, ordered from least to
+// most verbose. -loglevel enables the requested level plus everything
+// before it in this list, since gologme requires EnableLevel to be called
+// individually for each level you want turned on.
+var logLevels = []string{"error", "warn", "info", "debug", "trace"}
+
+// setLogLevel enables the given level and everything less verbose than it on
+// the logger. An unrecognised level falls back to "info", matching the
+// default that upstream yggdrasil uses.
+func setLogLevel(level string, logger *log.Logger) {
+	known := false
+	for _, l := range logLevels {
+		if l == level {
+			known = true
+			break
+		}
+	}
+	if !known {
+		fmt.Println("Unknown log level", level, "- defaulting to info")
+		level = "info"
+	}
+	for _, l := range logLevels {
+		logger.EnableLevel(l)
+		if l == level {
+			break
+		}
+	}
+}
+
+// newLogger builds a logger that writes to stdout, stderr, syslog or a file
+// on disk, depending on the -logto flag, in the same way that upstream
+// yggdrasil's main.go wires up its logger.
+func newLogger(logto string) *log.Logger {
+	switch logto {
+	case "stdout", "":
+		return log.New(os.Stdout, "", log.Flags())
+	case "stderr":
+		return log.New(os.Stderr, "", log.Flags())
+	case "syslog":
+		if syslogger, err := gsyslog.NewLogger(gsyslog.LOG_NOTICE, "DAEMON", "yggcrawl"); err == nil {
+			return log.New(syslogger, "", 0)
+		}
+		fmt.Println("Failed to connect to syslog - falling back to stdout")
+		return log.New(os.Stdout, "", log.Flags())
+	default:
+		if logfd, err := os.OpenFile(logto, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644); err == nil {
+			return log.New(logfd, "", log.Flags())
+		}
+		fmt.Println("Failed to open log file", logto, "- falling back to stdout")
+		return log.New(os.Stdout, "", log.Flags())
+	}
+}
@@ -21,15 +21,15 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
-	"io/ioutil"
-	"math/rand"
 	"net"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gologme/log"
 	"github.com/yggdrasil-network/yggdrasil-go/src/address"
+	"github.com/yggdrasil-network/yggdrasil-go/src/admin"
 	"github.com/yggdrasil-network/yggdrasil-go/src/config"
 	"github.com/yggdrasil-network/yggdrasil-go/src/crypto"
 	"github.com/yggdrasil-network/yggdrasil-go/src/multicast"
@@ -38,15 +38,30 @@ import (
 
 var defaultPeer = flag.String("peer", "", "static peer to use, e.g. tcp://host:port")
 var defaultMulticast = flag.Bool("multicast", false, "whether to enable multicast peering")
-var defaultFilename = flag.String("file", "results.json", "filename to write results to")
-var defaultAdminSocket = flag.String("admin", "none", "admin socket path, e.g. unix:///var/run/yggcrawl.sock")
-var defaultRetryCount = flag.Int("retry", 5, "number of retry attempts (with random exponential backoff starting at 1s)")
+var defaultFilename = flag.String("file", "", "filename to write results to (overrides the config file, default results.json)")
+var defaultAdminSocket = flag.String("admin", "", "admin socket path, e.g. unix:///var/run/yggcrawl.sock (overrides the config file)")
+var defaultRetryCount = flag.Int("retry", 0, "number of retry attempts, with random exponential backoff starting at 1s (overrides the config file)")
+var useconf = flag.Bool("useconf", false, "read HJSON/JSON config from stdin")
+var useconffile = flag.String("useconffile", "", "read HJSON/JSON config from specified file path")
+var genconf = flag.Bool("genconf", false, "print a new config to stdout")
+var normaliseconf = flag.Bool("normaliseconf", false, "read HJSON/JSON config from stdin/-useconffile and print it normalised to stdout")
+var loglevel = flag.String("loglevel", "info", "loglevel to enable, one of: error, warn, info, debug, trace")
+var logto = flag.String("logto", "stdout", "file path to log to, \"stdout\", \"stderr\" or \"syslog\"")
+var probeSessions = flag.Bool("probe-sessions", false, "also try to establish a session with every node found in the DHT, to check it is actually reachable (overrides the config file)")
+var dhtWorkers = flag.Int("dht-workers", 0, "number of concurrent DHT ping workers (overrides the config file, default 32)")
+var nodeInfoWorkers = flag.Int("nodeinfo-workers", 0, "number of concurrent nodeinfo workers (overrides the config file, default 16)")
+var retryBaseFlag = flag.Int("retry-base", 0, "base retry backoff in milliseconds (overrides the config file, default 1000)")
+var retryCapFlag = flag.Int("retry-cap", 0, "maximum retry backoff in milliseconds (overrides the config file, default 60000)")
+var sessionProbeTimeoutFlag = flag.Int("session-probe-timeout", 0, "deadline for a session reachability probe, in milliseconds (overrides the config file, default 5000)")
+var metricsListen = flag.String("metrics-listen", "", "address to expose Prometheus metrics on, e.g. :9200 (disabled by default)")
+var crawlInterval = flag.Duration("crawl-interval", 0, "if set, repeat the crawl on this interval instead of exiting after one sweep, e.g. 1h")
 
 type node struct {
 	core              yggdrasil.Core
 	multicast         multicast.Multicast
+	admin             admin.AdminSocket
 	state             config.NodeState
-	config            *config.NodeConfig
+	config            *crawlerConfig
 	log               *log.Logger
 	dhtWaitGroup      sync.WaitGroup
 	dhtVisited        map[string]attempt
@@ -54,6 +69,14 @@ type node struct {
 	nodeInfoWaitGroup sync.WaitGroup
 	nodeInfoVisited   map[string]interface{}
 	nodeInfoMutex     sync.RWMutex
+	sessionWaitGroup  sync.WaitGroup
+	sessionSemaphore  chan struct{}
+	dhtJobs           chan dhtJob
+	nodeInfoJobs      chan nodeInfoJob
+	stopping          int32
+	crawlMutex        sync.Mutex
+	crawling          bool
+	crawlStart        time.Time
 }
 
 // This is the structure that we marshal at the end into JSON results
@@ -66,147 +89,178 @@ type results struct {
 		NodesFailed        int     `json:"nodes_failed"`
 		NodeInfoSuccessful int     `json:"nodeinfo_successful"`
 		NodeInfoFailed     int     `json:"nodeinfo_failed"`
+		DHTVisible         int     `json:"nodes_dht_visible"`
+		SessionReachable   int     `json:"nodes_session_reachable"`
 	} `json:"meta"`
 	Topology *map[string]attempt     `json:"topology"`
 	NodeInfo *map[string]interface{} `json:"nodeinfo"`
 }
 
 type attempt struct {
-	NodeID     string   `json:"node_id"`     // the node ID
-	IPv6Addr   string   `json:"ipv6_addr"`   // the node address
-	IPv6Subnet string   `json:"ipv6_subnet"` // the node subnet
-	Coords     []uint64 `json:"coords"`      // the coordinates of the node
-	Found      bool     `json:"found"`       // has a search for this node completed successfully?
+	NodeID     string   `json:"node_id"`               // the node ID
+	IPv6Addr   string   `json:"ipv6_addr"`             // the node address
+	IPv6Subnet string   `json:"ipv6_subnet"`           // the node subnet
+	Coords     []uint64 `json:"coords"`                // the coordinates of the node
+	Found      bool     `json:"found"`                 // has a search for this node completed successfully?
+	Reachable  bool     `json:"reachable,omitempty"`   // could we establish a session with this node?
+	SessionRTT float64  `json:"session_rtt,omitempty"` // round-trip time of the session probe, in seconds
 }
 
 func main() {
 	flag.Parse()
 
+	switch {
+	case *genconf:
+		fmt.Println(doGenconf(generateCrawlerConfig()))
+		return
+	case *normaliseconf:
+		fmt.Println(doGenconf(readConfig(*useconffile)))
+		return
+	}
+
 	n := node{
-		config: config.GenerateConfig(),
-		log:    log.New(os.Stdout, "", log.Flags()),
+		log: newLogger(*logto),
+	}
+	setLogLevel(*loglevel, n.log)
+
+	if *useconf {
+		n.config = readConfig("")
+	} else if *useconffile != "" {
+		n.config = readConfig(*useconffile)
+	} else {
+		n.config = generateCrawlerConfig()
+	}
+
+	// Flags take priority over whatever was set in the config file, so that
+	// a quick one-off crawl doesn't require writing out a config file first.
+	if *defaultFilename != "" {
+		n.config.OutputFile = *defaultFilename
+	}
+	if *defaultAdminSocket != "" {
+		n.config.AdminListen = *defaultAdminSocket
+	}
+	if *defaultRetryCount != 0 {
+		n.config.RetryCount = *defaultRetryCount
+	}
+	if *defaultPeer != "" {
+		n.config.Peers = append(n.config.Peers, *defaultPeer)
+	}
+	if *probeSessions {
+		n.config.SessionProbeEnabled = true
+	}
+	if *dhtWorkers != 0 {
+		n.config.DHTWorkers = *dhtWorkers
+	}
+	if *nodeInfoWorkers != 0 {
+		n.config.NodeInfoWorkers = *nodeInfoWorkers
+	}
+	if *retryBaseFlag != 0 {
+		n.config.RetryBase = *retryBaseFlag
+	}
+	if *retryCapFlag != 0 {
+		n.config.RetryCap = *retryCapFlag
+	}
+	if *sessionProbeTimeoutFlag != 0 {
+		n.config.SessionProbeTimeout = *sessionProbeTimeoutFlag
 	}
 
-	if *defaultPeer == "" && *defaultMulticast == false {
-		fmt.Println("No peer has been specified, see -help")
+	if len(n.config.Peers) == 0 && !*defaultMulticast {
+		n.log.Errorln("No peer has been specified, see -help")
 		return
 	}
 
 	n.dhtVisited = make(map[string]attempt)
 	n.nodeInfoVisited = make(map[string]interface{})
 
-	n.config.NodeInfo = map[string]interface{}{
-		"name": "Yggdrasil Crawler",
+	if n.config.SessionProbeEnabled {
+		// Being visible in the DHT says nothing about whether a node can
+		// actually carry session traffic, which is what the reachability
+		// probe is for. We have no way of knowing the public keys of nodes
+		// we're about to crawl ahead of time, so the firewall's allowlist is
+		// no use here - instead we relax it for outbound sessions only,
+		// i.e. ones that we ourselves initiate. Unsolicited inbound sessions
+		// are still rejected as before.
+		n.config.SessionFirewall.AlwaysAllowOutbound = true
+		if n.config.SessionProbeWorkers <= 0 {
+			n.config.SessionProbeWorkers = 16
+		}
+		n.sessionSemaphore = make(chan struct{}, n.config.SessionProbeWorkers)
 	}
-	n.config.AdminListen = *defaultAdminSocket
-	n.config.SessionFirewall.Enable = true
-	n.config.SessionFirewall.AllowFromDirect = false
-	n.config.SessionFirewall.AllowFromRemote = false
-	n.config.SessionFirewall.AlwaysAllowOutbound = false
-	n.core.Start(n.config, n.log)
 
-	if *defaultPeer != "" {
-		if err := n.core.CallPeer(*defaultPeer, ""); err != nil {
-			fmt.Println("Failed to connect to peer:", err)
-			return
+	if *metricsListen != "" {
+		startMetricsServer(*metricsListen, n.log)
+	}
+
+	n.startWorkerPools()
+	go n.watchForShutdown()
+
+	n.core.Start(&n.config.NodeConfig, n.log)
+
+	if err := n.admin.Init(&n.core, &n.state, n.log, nil); err != nil {
+		n.log.Errorln("An error occurred initialising the admin socket:", err)
+		return
+	}
+	n.registerAdminHandlers()
+	if err := n.admin.Start(); err != nil {
+		n.log.Errorln("An error occurred starting the admin socket:", err)
+		return
+	}
+
+	go n.watchForReload()
+
+	for _, peer := range n.config.Peers {
+		if err := n.core.CallPeer(peer, ""); err != nil {
+			n.log.Warnln("Failed to connect to peer", peer, ":", err)
 		}
 	}
 
 	if *defaultMulticast {
 		if err := n.multicast.Init(&n.core, &n.state, n.log, nil); err != nil {
-			log.Errorln("An error occurred initialising multicast:", err)
+			n.log.Errorln("An error occurred initialising multicast:", err)
 			return
 		}
 		if err := n.multicast.Start(); err != nil {
-			log.Errorln("An error occurred starting multicast:", err)
+			n.log.Errorln("An error occurred starting multicast:", err)
 			return
 		}
-		fmt.Println("Multicast is enabled on", len(n.multicast.Interfaces()), "interface(s)")
+		n.log.Infoln("Multicast is enabled on", len(n.multicast.Interfaces()), "interface(s)")
 	}
 
-	fmt.Println("Waiting for peers")
+	n.log.Infoln("Waiting for peers")
 	for {
 		if len(n.core.GetSwitchPeers()) > 0 {
 			break
 		}
 		time.Sleep(time.Second)
 	}
-	fmt.Println("Connected to", len(n.core.GetSwitchPeers()), "peer(s)")
+	n.log.Infoln("Connected to", len(n.core.GetSwitchPeers()), "peer(s)")
 
-	fmt.Println("Waiting for DHT bootstrap")
+	n.log.Infoln("Waiting for DHT bootstrap")
 	for {
-		if len(n.core.GetDHT()) > 3 {
+		if len(n.core.GetDHT()) > n.config.DHTBootstrapThreshold {
 			break
 		}
 		time.Sleep(time.Second)
 	}
-	fmt.Println("DHT bootstrap complete")
-
-	starttime := time.Now()
-	fmt.Println("Our network coords are", n.core.Coords())
-	fmt.Println("Starting crawl")
+	n.log.Infoln("DHT bootstrap complete")
 
-	if key, err := hex.DecodeString(n.core.EncryptionPublicKey()); err == nil {
-		var pubkey crypto.BoxPubKey
-		copy(pubkey[:], key)
-		n.dhtWaitGroup.Add(1)
-		go n.dhtPing(pubkey, n.core.Coords())
+	if *crawlInterval > 0 {
+		n.runPeriodic(*crawlInterval)
 	} else {
-		panic("failed to decode pub key")
+		n.runCrawl()
 	}
-
-	n.dhtWaitGroup.Wait()
-	n.nodeInfoWaitGroup.Wait()
-
-	n.dhtMutex.Lock()
-	n.nodeInfoMutex.Lock()
-
-	fmt.Println()
-	fmt.Println("The crawl took", time.Since(starttime))
-
-	attempted := len(n.dhtVisited)
-	found := 0
-	for _, attempt := range n.dhtVisited {
-		if attempt.Found {
-			found++
-		}
-	}
-
-	res := results{
-		Topology: &n.dhtVisited,
-		NodeInfo: &n.nodeInfoVisited,
-	}
-	res.Meta.GeneratedAtUTC = time.Now().UTC().Unix()
-	res.Meta.TimeTaken = time.Since(starttime).Seconds()
-	res.Meta.NodeInfoSuccessful = len(n.nodeInfoVisited)
-	res.Meta.NodeInfoFailed = found - len(n.nodeInfoVisited)
-	res.Meta.NodesAttempted = attempted
-	res.Meta.NodesSuccessful = found
-	res.Meta.NodesFailed = attempted - found
-
-	if j, err := json.MarshalIndent(res, "", "\t"); err == nil {
-		if err := ioutil.WriteFile(*defaultFilename, j, 0644); err != nil {
-			fmt.Printf("Failed to write %s: %v", *defaultFilename, err)
-		} else {
-			fmt.Println("Results written to", *defaultFilename)
-		}
-	} else {
-		fmt.Println("Failed to marshal results:", err)
-	}
-
-	fmt.Println()
-	fmt.Println(res.Meta.NodesAttempted, "nodes were processed")
-	fmt.Println(res.Meta.NodesSuccessful, "nodes were found")
-	fmt.Println(res.Meta.NodesFailed, "nodes were not found")
-	fmt.Println()
-	fmt.Println(res.Meta.NodesSuccessful, "nodes responded with nodeinfo")
-	fmt.Println(res.Meta.NodesFailed, "nodes did not respond with nodeinfo")
 }
 
+// dhtPing is run by a dhtWorker for a job that enqueueDHTPing has already
+// admitted - the dedupe check has already happened, so this just needs to
+// perform the ping (with retries) and record the outcome.
 func (n *node) dhtPing(pubkey crypto.BoxPubKey, coords []uint64) {
 	// Notify the main goroutine that we're done working
 	defer n.dhtWaitGroup.Done()
 
+	metricInflightDHT.Inc()
+	defer metricInflightDHT.Dec()
+
 	// Generate useful information about the node, such as it's node ID, address
 	// and subnet
 	key := hex.EncodeToString(pubkey[:])
@@ -215,35 +269,39 @@ func (n *node) dhtPing(pubkey crypto.BoxPubKey, coords []uint64) {
 	upper := append(address.SubnetForNodeID(nodeid)[:], 0, 0, 0, 0, 0, 0, 0, 0)
 	subnet := net.IPNet{IP: upper, Mask: net.CIDRMask(64, 128)}
 
-	// If we already have an entry of this node then we should stop what we're
-	// doing - it either means that a search is already taking place, or that we
-	// have already processed this node
-	n.dhtMutex.RLock()
-	if info := n.dhtVisited[key]; info.Found {
-		n.dhtMutex.RUnlock()
-		return
-	}
-	n.dhtMutex.RUnlock()
-
-	// Make a record of this node and the coordinates so that future goroutines
-	// started by a rumour of this node will not repeat this search
 	var res yggdrasil.DHTRes
 	var err error
-	for idx := 0; idx < *defaultRetryCount; idx++ {
-		// Randomized delay between attempts, increases exponentially
-		time.Sleep(time.Millisecond * time.Duration(rand.Intn(1000)*(1<<idx)))
+	attempts := 0
+	for idx := 0; idx < n.config.RetryCount; idx++ {
+		if atomic.LoadInt32(&n.stopping) != 0 {
+			break
+		}
+		attempts = idx + 1
+		// Bounded exponential backoff with jitter between attempts
+		time.Sleep(retryBackoff(n.config.RetryBase, n.config.RetryCap, idx))
 		// Send out a DHT ping request into the network
+		start := time.Now()
 		res, err = n.core.DHTPing(
 			pubkey,
 			coords,
 			&crypto.NodeID{},
 		)
+		metricRequestDuration.WithLabelValues("dht_ping").Observe(time.Since(start).Seconds())
+		n.log.Traceln("DHT ping", key, "coords", coords, "retry", idx, "rtt", time.Since(start), "err", err)
 		if err == nil {
 			break
 		}
 	}
+	metricRetryAttempts.Observe(float64(attempts))
+	if attempts == 0 {
+		// No ping was sent at all, so this node must not be recorded as found
+		err = fmt.Errorf("no DHT ping was sent to %s", key)
+	}
+	metricDHTPingsTotal.WithLabelValues(requestResult(err, atomic.LoadInt32(&n.stopping) != 0)).Inc()
 
-	// Write our new information into the list of visited DHT nodes
+	// Write our new information into the list of visited DHT nodes, replacing
+	// the placeholder that enqueueDHTPing reserved; a failed attempt is
+	// recorded with Found set to false
 	info := attempt{
 		NodeID:     nodeid.String(),
 		IPv6Addr:   addr.String(),
@@ -252,68 +310,99 @@ func (n *node) dhtPing(pubkey crypto.BoxPubKey, coords []uint64) {
 		Found:      err == nil,
 	}
 
-	// If we successfully found the node then update dhtVisited to say so
 	n.dhtMutex.Lock()
-	defer n.dhtMutex.Unlock()
-	oldInfo := n.dhtVisited[key]
-	if info.Found || !oldInfo.Found {
-		n.dhtVisited[key] = info
-	}
+	n.dhtVisited[key] = info
+	metricDHTVisited.Set(float64(len(n.dhtVisited)))
+	n.dhtMutex.Unlock()
 
-	// If this was the first response from this node then request nodeinfo
-	if !oldInfo.Found && info.Found {
-		n.nodeInfoWaitGroup.Add(1)
-		go n.nodeInfo(pubkey, coords)
-	} else {
-		// This isn't our first response from the node, so don't do anything
+	if !info.Found {
 		return
 	}
 
-	// Start new DHT search goroutines based on the rumours included in the DHT
-	// ping response
-	for _, info := range res.Infos {
-		n.dhtWaitGroup.Add(1)
-		go n.dhtPing(info.PublicKey, info.Coords)
+	metricNodeInfo.WithLabelValues(info.NodeID, info.IPv6Addr, coordsLabel(coords)).Set(1)
+
+	// Request nodeinfo and, if enabled, probe whether we can actually
+	// establish a session with this node
+	n.enqueueNodeInfo(pubkey, coords)
+	if n.config.SessionProbeEnabled {
+		n.sessionWaitGroup.Add(1)
+		go n.probeSession(pubkey, key)
+	}
+
+	// Queue up DHT searches for the rumours included in the ping response
+	for _, rumour := range res.Infos {
+		n.enqueueDHTPing(rumour.PublicKey, rumour.Coords)
 	}
 }
 
+// requestResult classifies a finished DHT ping or nodeinfo attempt for its
+// corresponding _total counter: "ok" on success, "error" if we gave up
+// because the crawler is shutting down, and "timeout" if retries were simply
+// exhausted.
+func requestResult(err error, stopping bool) string {
+	switch {
+	case err == nil:
+		return "ok"
+	case stopping:
+		return "error"
+	default:
+		return "timeout"
+	}
+}
+
+// nodeInfo is run by a nodeInfoWorker for a job that enqueueNodeInfo has
+// already admitted.
 func (n *node) nodeInfo(pubkey crypto.BoxPubKey, coords []uint64) {
 	// Notify the main goroutine that we're done working
 	defer n.nodeInfoWaitGroup.Done()
 
-	// Store information that says that we attempted to query this node for
-	// nodeinfo
+	metricInflightNodeInfo.Inc()
+	defer metricInflightNodeInfo.Dec()
+
 	key := hex.EncodeToString(pubkey[:])
-	n.nodeInfoMutex.RLock()
-	if _, ok := n.nodeInfoVisited[key]; ok {
-		n.nodeInfoMutex.RUnlock()
-		return
-	}
-	n.nodeInfoMutex.RUnlock()
 
 	// send the nodeinfo request to the given coordinates
 	var res yggdrasil.NodeInfoPayload
 	var err error
-	for idx := 0; idx < *defaultRetryCount; idx++ {
-		time.Sleep(time.Millisecond * time.Duration(rand.Intn(1000)*(1<<idx)))
+	attempts := 0
+	for idx := 0; idx < n.config.RetryCount; idx++ {
+		if atomic.LoadInt32(&n.stopping) != 0 {
+			break
+		}
+		attempts = idx + 1
+		time.Sleep(retryBackoff(n.config.RetryBase, n.config.RetryCap, idx))
+		start := time.Now()
 		res, err = n.core.GetNodeInfo(pubkey, coords, false)
+		metricRequestDuration.WithLabelValues("nodeinfo").Observe(time.Since(start).Seconds())
+		n.log.Traceln("nodeinfo request", key, "coords", coords, "retry", idx, "rtt", time.Since(start), "err", err)
 		if err == nil {
 			break
 		}
 	}
+	metricRetryAttempts.Observe(float64(attempts))
 	if err != nil {
+		// The lookup never succeeded, so drop the placeholder enqueueNodeInfo
+		// reserved rather than leaving a null entry in the results
+		metricNodeInfoRequestsTotal.WithLabelValues(requestResult(err, atomic.LoadInt32(&n.stopping) != 0)).Inc()
+		n.nodeInfoMutex.Lock()
+		delete(n.nodeInfoVisited, key)
+		metricNodeInfoVisited.Set(float64(len(n.nodeInfoVisited)))
+		n.nodeInfoMutex.Unlock()
 		return
 	}
 
-	n.nodeInfoMutex.Lock()
-	defer n.nodeInfoMutex.Unlock()
-
 	// If we received nodeinfo back then try to unmarshal it and store it in our
 	// received nodeinfos
 	var j interface{}
+	n.nodeInfoMutex.Lock()
+	defer n.nodeInfoMutex.Unlock()
 	if err := json.Unmarshal(res, &j); err != nil {
-		fmt.Println(err)
+		n.log.Warnln("Failed to unmarshal nodeinfo from", key, ":", err)
+		delete(n.nodeInfoVisited, key)
+		metricNodeInfoRequestsTotal.WithLabelValues("error").Inc()
 	} else {
 		n.nodeInfoVisited[key] = j
+		metricNodeInfoRequestsTotal.WithLabelValues("ok").Inc()
 	}
+	metricNodeInfoVisited.Set(float64(len(n.nodeInfoVisited)))
 }
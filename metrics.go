@@ -0,0 +1,106 @@
+// yggcrawl
+// Copyright (C) 2020 Neil Alexander
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// 	the Free Software Foundation, either version 3 of the License, or
+// 	(at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gologme/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	metricDHTPingsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "yggcrawl_dht_pings_total",
+		Help: "Total number of DHT ping requests, by outcome after all retries.",
+	}, []string{"result"})
+
+	metricNodeInfoRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "yggcrawl_nodeinfo_requests_total",
+		Help: "Total number of nodeinfo requests, by outcome.",
+	}, []string{"result"})
+
+	metricDHTVisited = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "yggcrawl_dht_visited",
+		Help: "Number of distinct nodes seen during the current (or most recent) crawl's DHT traversal.",
+	})
+
+	metricNodeInfoVisited = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "yggcrawl_nodeinfo_visited",
+		Help: "Number of nodes that have returned nodeinfo during the current (or most recent) crawl.",
+	})
+
+	metricInflightDHT = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "yggcrawl_inflight_dht",
+		Help: "Number of DHT ping jobs currently being worked on.",
+	})
+
+	metricInflightNodeInfo = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "yggcrawl_inflight_nodeinfo",
+		Help: "Number of nodeinfo jobs currently being worked on.",
+	})
+
+	metricRetryAttempts = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "yggcrawl_retry_attempts",
+		Help:    "Number of attempts taken per request before it either succeeded or was given up on.",
+		Buckets: prometheus.LinearBuckets(1, 1, 10),
+	})
+
+	metricRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "yggcrawl_request_duration_seconds",
+		Help:    "Duration of a single DHT ping or nodeinfo request attempt, by kind.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"kind"})
+
+	metricLastCrawlFinish = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "yggcrawl_last_crawl_finish_timestamp_seconds",
+		Help: "Unix timestamp at which the most recent crawl finished writing its results.",
+	})
+
+	metricNodeInfo = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "yggcrawl_node_info",
+		Help: "Always 1, present for every node found during the current crawl so Grafana can join on its labels to build a live topology view.",
+	}, []string{"node_id", "ipv6_addr", "coords"})
+)
+
+// startMetricsServer exposes the registered Prometheus collectors on
+// listen/metrics. It is only called when -metrics-listen is set, so the
+// crawler carries no observability overhead by default.
+func startMetricsServer(listen string, logger *log.Logger) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	go func() {
+		if err := http.ListenAndServe(listen, mux); err != nil {
+			logger.Errorln("Metrics server failed:", err)
+		}
+	}()
+	logger.Infoln("Metrics are being exposed on", listen+"/metrics")
+}
+
+// coordsLabel renders a coordinate set as a comma-separated string, since
+// Prometheus label values must be strings rather than slices.
+func coordsLabel(coords []uint64) string {
+	parts := make([]string, len(coords))
+	for i, c := range coords {
+		parts[i] = strconv.FormatUint(c, 10)
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,70 @@
+// yggcrawl
+// Copyright (C) 2020 Neil Alexander
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// 	the Free Software Foundation, either version 3 of the License, or
+// 	(at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// watchForReload listens for SIGHUP and, if the crawler was started with
+// -useconffile, re-reads the configuration file and hands any new static
+// peers to the running core. This lets a long-running crawl pick up peers
+// that were added to the config after it started without a restart.
+func (n *node) watchForReload() {
+	if *useconffile == "" {
+		return
+	}
+	sigHup := make(chan os.Signal, 1)
+	signal.Notify(sigHup, syscall.SIGHUP)
+	for range sigHup {
+		n.reloadConfig()
+	}
+}
+
+// reloadConfig re-reads -useconffile and hands any new static peers to the
+// running core. readConfig panics on anything from a missing file to a typo
+// in the HJSON, which is fine at startup but would otherwise take down a
+// crawl that's hours into a sweep over a bad SIGHUP reload, so we recover and
+// just keep running on the config we already have.
+func (n *node) reloadConfig() {
+	defer func() {
+		if r := recover(); r != nil {
+			n.log.Errorln("Failed to reload config, keeping existing config:", r)
+		}
+	}()
+
+	newConf := readConfig(*useconffile)
+	existing := make(map[string]bool, len(n.config.Peers))
+	for _, peer := range n.config.Peers {
+		existing[peer] = true
+	}
+	added := 0
+	for _, peer := range newConf.Peers {
+		if existing[peer] {
+			continue
+		}
+		if err := n.core.CallPeer(peer, ""); err != nil {
+			n.log.Warnln("Failed to connect to new peer", peer, ":", err)
+			continue
+		}
+		added++
+	}
+	n.config.Peers = newConf.Peers
+	n.log.Infoln("Reloaded config on SIGHUP,", added, "new peer(s) added")
+}
@@ -0,0 +1,53 @@
+// yggcrawl
+// Copyright (C) 2020 Neil Alexander
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// 	the Free Software Foundation, either version 3 of the License, or
+// 	(at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// runPeriodic repeats runCrawl on a fixed interval instead of the usual
+// single-sweep-then-exit behaviour, archiving results.json to a timestamped
+// file after each sweep so that a long-running crawler keeps history instead
+// of clobbering the previous run's output.
+func (n *node) runPeriodic(interval time.Duration) {
+	for {
+		n.runCrawl()
+		n.archiveResults()
+		n.log.Infoln("Next crawl scheduled in", interval)
+		time.Sleep(interval)
+	}
+}
+
+// archiveResults renames the just-written OutputFile out of the way to
+// <base>-<unix timestamp><ext>, so that the next call to writeResults starts
+// a fresh results.json rather than appending to or overwriting history.
+func (n *node) archiveResults() {
+	ext := filepath.Ext(n.config.OutputFile)
+	base := strings.TrimSuffix(n.config.OutputFile, ext)
+	archived := fmt.Sprintf("%s-%d%s", base, time.Now().Unix(), ext)
+
+	if err := os.Rename(n.config.OutputFile, archived); err != nil {
+		n.log.Warnln("Failed to archive", n.config.OutputFile, "to", archived, ":", err)
+		return
+	}
+	n.log.Infoln("Archived results to", archived)
+}
@@ -0,0 +1,76 @@
+// yggcrawl
+// Copyright (C) 2020 Neil Alexander
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// 	the Free Software Foundation, either version 3 of the License, or
+// 	(at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"time"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/crypto"
+)
+
+// probeSession tries to establish an actual end-to-end session with a node
+// that has already responded to a DHT ping, since being visible in the DHT
+// does not prove that a node can accept traffic - it may be advertising
+// stale coords, or be firewalled. It uses its own semaphore, separate from
+// dhtWaitGroup, so that a slow or hanging session dial can't stall the rest
+// of the DHT traversal.
+func (n *node) probeSession(pubkey crypto.BoxPubKey, key string) {
+	defer n.sessionWaitGroup.Done()
+
+	n.sessionSemaphore <- struct{}{}
+	defer func() { <-n.sessionSemaphore }()
+
+	timeout := time.Duration(n.config.SessionProbeTimeout) * time.Millisecond
+
+	start := time.Now()
+	conn, err := n.core.Dial("nodeid", key)
+	if err != nil {
+		n.log.Traceln("Session probe to", key, "failed to dial:", err)
+		return
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		n.log.Traceln("Session probe to", key, "failed to set deadline:", err)
+		return
+	}
+
+	// A plain Yggdrasil node has no listener speaking an ad-hoc probe
+	// protocol back to us, so waiting on a reply here would just block until
+	// the deadline on every reachable node. The session handshake itself is
+	// what proves reachability: Dial doesn't return until it completes, and
+	// a successful Write confirms the resulting session can actually carry
+	// traffic outbound, which is as far as a keepalive-style probe can get
+	// without cooperation from the remote end.
+	reachable := false
+	if _, err := conn.Write([]byte{0}); err == nil {
+		reachable = true
+	} else {
+		n.log.Traceln("Session probe to", key, "failed to write:", err)
+	}
+	rtt := time.Since(start).Seconds()
+
+	n.log.Traceln("Session probe to", key, "reachable", reachable, "rtt", rtt)
+
+	n.dhtMutex.Lock()
+	defer n.dhtMutex.Unlock()
+	if info, ok := n.dhtVisited[key]; ok {
+		info.Reachable = reachable
+		info.SessionRTT = rtt
+		n.dhtVisited[key] = info
+	}
+}
@@ -0,0 +1,75 @@
+// yggcrawl
+// Copyright (C) 2020 Neil Alexander
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// 	the Free Software Foundation, either version 3 of the License, or
+// 	(at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// drainTimeout bounds how long watchForShutdown waits for each of the
+// in-flight DHT pings, nodeinfo lookups and session probes in turn to finish
+// up before giving up on that stage; once all stages are done it writes out
+// whatever results are available.
+const drainTimeout = 10 * time.Second
+
+// watchForShutdown listens for SIGINT/SIGTERM. On receipt it stops
+// enqueueDHTPing/enqueueNodeInfo from admitting any more work, gives
+// in-flight requests a chance to drain, and then writes out a partial
+// results.json rather than losing the whole crawl the way a plain Ctrl-C
+// used to.
+func (n *node) watchForShutdown() {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	<-sigCh
+
+	n.log.Infoln("Shutting down, draining in-flight requests...")
+	atomic.StoreInt32(&n.stopping, 1)
+
+	if !waitWithTimeout(&n.dhtWaitGroup, drainTimeout) {
+		n.log.Warnln("Timed out waiting for DHT pings to drain")
+	}
+	if !waitWithTimeout(&n.nodeInfoWaitGroup, drainTimeout) {
+		n.log.Warnln("Timed out waiting for nodeinfo lookups to drain")
+	}
+	if n.sessionSemaphore != nil && !waitWithTimeout(&n.sessionWaitGroup, drainTimeout) {
+		n.log.Warnln("Timed out waiting for session probes to drain")
+	}
+
+	n.writeResults()
+	os.Exit(0)
+}
+
+// waitWithTimeout waits for wg, or gives up once timeout has elapsed,
+// whichever comes first.
+func waitWithTimeout(wg *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
@@ -0,0 +1,153 @@
+// yggcrawl
+// Copyright (C) 2020 Neil Alexander
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// 	the Free Software Foundation, either version 3 of the License, or
+// 	(at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/hex"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/yggdrasil-network/yggdrasil-go/src/crypto"
+)
+
+// jobQueueSize bounds how many pending rumours can sit in a job channel
+// before enqueueDHTPing/enqueueNodeInfo start dropping them. It is sized
+// generously relative to the worker counts so that a burst of rumours from
+// a popular node doesn't spuriously drop work under normal conditions.
+const jobQueueSize = 8192
+
+type dhtJob struct {
+	pubkey crypto.BoxPubKey
+	coords []uint64
+}
+
+type nodeInfoJob struct {
+	pubkey crypto.BoxPubKey
+	coords []uint64
+}
+
+// startWorkerPools creates the bounded DHT ping and nodeinfo job queues and
+// starts the fixed-size pools of workers that drain them. Unlike the
+// previous one-goroutine-per-rumour approach, this keeps a ~5k-node crawl
+// from spawning thousands of concurrent DHTPing calls and exhausting the
+// switch's send queue. The pools are started once and outlive any single
+// crawl, since restartCrawl reuses them.
+func (n *node) startWorkerPools() {
+	// A worker count of zero (or less) spawns no workers at all, so jobs
+	// would queue up and the crawl would hang forever with nothing draining
+	// them and no error to explain why. Fall back to the same defaults
+	// generateCrawlerConfig uses, the way SessionProbeWorkers already does.
+	if n.config.DHTWorkers <= 0 {
+		n.config.DHTWorkers = 32
+	}
+	if n.config.NodeInfoWorkers <= 0 {
+		n.config.NodeInfoWorkers = 16
+	}
+
+	n.dhtJobs = make(chan dhtJob, jobQueueSize)
+	n.nodeInfoJobs = make(chan nodeInfoJob, jobQueueSize)
+
+	for i := 0; i < n.config.DHTWorkers; i++ {
+		go n.dhtWorker()
+	}
+	for i := 0; i < n.config.NodeInfoWorkers; i++ {
+		go n.nodeInfoWorker()
+	}
+}
+
+func (n *node) dhtWorker() {
+	for job := range n.dhtJobs {
+		n.dhtPing(job.pubkey, job.coords)
+	}
+}
+
+func (n *node) nodeInfoWorker() {
+	for job := range n.nodeInfoJobs {
+		n.nodeInfo(job.pubkey, job.coords)
+	}
+}
+
+// enqueueDHTPing admits a rumour into the DHT ping queue, using the visited
+// map itself as the dedupe filter: the first rumour for a given key reserves
+// a placeholder entry and gets queued, every subsequent rumour for the same
+// key is dropped here rather than spawning another goroutine for a search
+// that is already in flight or already finished.
+func (n *node) enqueueDHTPing(pubkey crypto.BoxPubKey, coords []uint64) {
+	if atomic.LoadInt32(&n.stopping) != 0 {
+		return
+	}
+	key := hex.EncodeToString(pubkey[:])
+
+	n.dhtMutex.Lock()
+	if _, ok := n.dhtVisited[key]; ok {
+		n.dhtMutex.Unlock()
+		return
+	}
+	n.dhtVisited[key] = attempt{}
+	n.dhtMutex.Unlock()
+
+	n.dhtWaitGroup.Add(1)
+	select {
+	case n.dhtJobs <- dhtJob{pubkey: pubkey, coords: coords}:
+	default:
+		n.log.Warnln("DHT ping queue is full, dropping rumour for", key)
+		n.dhtWaitGroup.Done()
+	}
+}
+
+// enqueueNodeInfo admits a nodeinfo lookup into the queue, using
+// nodeInfoVisited as the dedupe filter in the same way enqueueDHTPing uses
+// dhtVisited.
+func (n *node) enqueueNodeInfo(pubkey crypto.BoxPubKey, coords []uint64) {
+	if atomic.LoadInt32(&n.stopping) != 0 {
+		return
+	}
+	key := hex.EncodeToString(pubkey[:])
+
+	n.nodeInfoMutex.Lock()
+	if _, ok := n.nodeInfoVisited[key]; ok {
+		n.nodeInfoMutex.Unlock()
+		return
+	}
+	n.nodeInfoVisited[key] = nil
+	n.nodeInfoMutex.Unlock()
+
+	n.nodeInfoWaitGroup.Add(1)
+	select {
+	case n.nodeInfoJobs <- nodeInfoJob{pubkey: pubkey, coords: coords}:
+	default:
+		n.log.Warnln("Nodeinfo queue is full, dropping lookup for", key)
+		n.nodeInfoWaitGroup.Done()
+	}
+}
+
+// retryBackoff returns a bounded exponential backoff with jitter:
+// min(cap, base*2^idx) * (0.5 + rand), so that a crawl retrying a
+// slow or unreachable node can't balloon into minutes-long sleeps the
+// way the old unbounded exponential backoff could.
+func retryBackoff(base, capMillis, idx int) time.Duration {
+	d := base * (1 << uint(idx))
+	// base == 0 is a legitimate "retry immediately" setting, not overflow -
+	// only clamp when the shift actually overflowed into a negative number,
+	// or genuinely exceeded the cap.
+	if d < 0 || d > capMillis {
+		d = capMillis
+	}
+	jitter := 0.5 + rand.Float64()
+	return time.Duration(float64(d)*jitter) * time.Millisecond
+}